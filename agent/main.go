@@ -2,74 +2,331 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
+	"math"
+	"math/rand"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
-)
 
-// ... (TelemetryData struct and consts remain the same) ...
-type TelemetryData struct {
-	AgentID      string    `json:"agent_id"`
-	Timestamp    time.Time `json:"timestamp"`
-	Temperature  float64   `json:"temperature"`
-	BatteryLevel int       `json:"battery_level"`
-}
+	"resilient-telemetry/agent/metrics"
+	"resilient-telemetry/agent/telemetry"
+	"resilient-telemetry/agent/transport"
+)
 
 const (
-	serverURL      = "http://localhost:8000/telemetry"
-	bufferFile     = "buffer.jsonl"
-	processingFile = "buffer_processing.jsonl"
+	bufferFile = "buffer.jsonl"
+
+	// seqStateFile persists the agent's outgoing sequence counter so a
+	// restart doesn't reuse sequence numbers the server may have already
+	// seen.
+	seqStateFile = "seq.state"
+
+	// segmentGlob matches every rotated-but-not-yet-uploaded buffer
+	// segment. Segments are gzip'd and timestamped rather than a single
+	// "buffer_processing.jsonl", so a long outage queues up many small
+	// segments instead of one file that grows without bound.
+	segmentGlob = "buffer_processing.*.jsonl.gz"
+
+	// batchSize is how many records go into a single batch send.
+	batchSize = 100
+
+	// MaxBufferBytes and MaxBufferAge trigger rotation of buffer.jsonl
+	// into a segment, whichever comes first.
+	MaxBufferBytes = 5 * 1024 * 1024 // 5 MiB
+	MaxBufferAge   = 60 * time.Second
+
+	// MaxTotalBufferBytes caps how much rotated (segment) data we keep on
+	// disk. Once exceeded, the oldest segment is dropped and its records
+	// counted in droppedRecordsTotal instead of growing forever.
+	MaxTotalBufferBytes = 100 * 1024 * 1024 // 100 MiB
+
+	// Retry backoff: base 1s, doubling up to a 60s cap, with +/-20% jitter
+	// so a fleet of agents reconnecting after an outage doesn't sync up
+	// and hammer the server in lockstep.
+	backoffBase      = 1 * time.Second
+	backoffCap       = 60 * time.Second
+	backoffFactor    = 2.0
+	backoffJitterPct = 0.20
+
+	// shutdownTimeout bounds how long we wait for the background flusher to
+	// finish its current cycle before we give up and force a final flush.
+	shutdownTimeout = 30 * time.Second
 )
 
 var fileMutex sync.Mutex
 
+// processBufferMu serializes whole processBuffer runs: the background
+// flusher's ticks and shutdown's final call both drive it, and on a
+// shutdown timeout the flusher's in-flight call may not have returned yet
+// even though wg.Wait() hasn't. Without this, the two could race on
+// batchAttempts, nextAttemptTime, bufferOpenedAt, ackedSequence, and on
+// uploading/removing the same segment file.
+var processBufferMu sync.Mutex
+
+// batchAttempts tracks retries for the oldest pending segment across
+// flusher ticks so backoff survives between calls to processBuffer.
+var (
+	batchAttempts   int
+	nextAttemptTime time.Time
+)
+
+// bufferOpenedAt is when the current buffer.jsonl was created, used to
+// enforce MaxBufferAge. It's reset whenever the file is rotated away.
+var bufferOpenedAt time.Time
+
+// seedBufferOpenedAt seeds bufferOpenedAt from buffer.jsonl's mtime if the
+// file already exists on disk at startup. Without this, a restart that
+// isn't a clean shutdown (crash, OOM-kill, SIGKILL) leaves buffer.jsonl in
+// place but bufferOpenedAt at its zero value for the rest of the run, which
+// permanently disables the MaxBufferAge half of rotation.
+func seedBufferOpenedAt() {
+	if info, err := os.Stat(bufferFile); err == nil {
+		bufferOpenedAt = info.ModTime()
+	}
+}
+
+// droppedRecordsTotal counts records discarded because MaxTotalBufferBytes
+// was exceeded and the oldest segment had to be dropped.
+var droppedRecordsTotal uint64
+
+// ackedSequence is the highest sequence number we know the server has
+// durably received: seeded from the startup ack handshake, advanced locally
+// after every successful batch, and re-synced via resyncAckedSequence
+// before retrying a segment that previously failed. After the startup
+// handshake it's only touched inside processBuffer, so like batchAttempts
+// and nextAttemptTime it's guarded by processBufferMu.
+var ackedSequence uint64
+
+// agentID identifies this agent to the collector. It's read by the main
+// loop and by processBuffer, which re-queries the server's ack via this ID
+// when retrying a failed segment.
+var agentID = "agent-001"
+
+// resyncAckedSequence queries the transport's AckSource (if it has one) for
+// the server's durable high-water mark and advances ackedSequence to it.
+// A retried batch can have partially succeeded before the client saw a
+// failure (timeout, dropped connection), so the local ackedSequence can
+// fall behind what the server actually durably holds; re-querying before a
+// retry closes that gap instead of only ever checking it once at startup.
+// Returns whether the query succeeded.
+func resyncAckedSequence(tr transport.Transport) bool {
+	ackSrc, ok := tr.(transport.AckSource)
+	if !ok {
+		return false
+	}
+
+	ackCtx, ackCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer ackCancel()
+
+	ack, err := ackSrc.LastAck(ackCtx, agentID)
+	if err != nil {
+		log.Printf("⚠️ Could not fetch server ack, assuming nothing acked: %v", err)
+		return false
+	}
+	if ack > ackedSequence {
+		ackedSequence = ack
+	}
+	return true
+}
+
+// loadSequence returns the last sequence number this agent used, or 0 if
+// seq.state doesn't exist yet.
+func loadSequence() uint64 {
+	content, err := os.ReadFile(seqStateFile)
+	if err != nil {
+		return 0
+	}
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// saveSequence persists seq so a restart resumes numbering after it instead
+// of reusing sequence numbers.
+func saveSequence(seq uint64) {
+	if err := os.WriteFile(seqStateFile, []byte(strconv.FormatUint(seq, 10)), 0644); err != nil {
+		log.Printf("❌ Error persisting sequence state: %v", err)
+	}
+}
+
+// backoffDelay returns the jittered exponential delay for the given
+// (zero-based) attempt number.
+func backoffDelay(attempt int) time.Duration {
+	delay := float64(backoffBase) * math.Pow(backoffFactor, float64(attempt))
+	if delay > float64(backoffCap) {
+		delay = float64(backoffCap)
+	}
+	jitter := delay * backoffJitterPct
+	delay += (rand.Float64()*2 - 1) * jitter
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
+
+// defaultTarget returns the conventional endpoint/address for a transport
+// kind when the operator doesn't pass --target explicitly.
+func defaultTarget(kind string) string {
+	switch kind {
+	case "grpc":
+		return "localhost:9000"
+	case "mqtt":
+		return "tcp://localhost:1883"
+	default:
+		return "http://localhost:8000/telemetry/batch"
+	}
+}
+
 func main() {
-	agentID := "agent-001"
-	fmt.Printf("🚀 Agent %s starting up (Linear Logic Mode)...\n", agentID)
+	transportKind := flag.String("transport", "http", "transport backend to use: http, grpc, or mqtt")
+	target := flag.String("target", "", "transport endpoint/address (defaults per --transport)")
+	metricsAddr := flag.String("metrics-addr", ":9090", "address to expose the Prometheus /metrics endpoint on")
+	flag.Parse()
+
+	endpoint := *target
+	if endpoint == "" {
+		endpoint = defaultTarget(*transportKind)
+	}
+
+	tr, err := transport.New(*transportKind, endpoint, agentID)
+	if err != nil {
+		log.Fatalf("❌ Failed to initialize %s transport: %v", *transportKind, err)
+	}
+	defer tr.Close()
+
+	go metrics.StartServer(*metricsAddr)
+
+	fmt.Printf("🚀 Agent %s starting up (%s transport -> %s)...\n", agentID, *transportKind, endpoint)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	seedBufferOpenedAt()
+
+	nextSequence := loadSequence()
+
+	if resyncAckedSequence(tr) {
+		fmt.Printf("🤝 Server has durably received up to sequence %d\n", ackedSequence)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	go flushBufferBackground()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		flushBufferBackground(ctx, tr)
+	}()
 
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		data := TelemetryData{
-			AgentID:      agentID,
-			Timestamp:    time.Now(),
-			Temperature:  23.5,
-			BatteryLevel: 85,
-		}
+runLoop:
+	for {
+		select {
+		case sig := <-sigCh:
+			fmt.Printf("🛑 Received %v, shutting down gracefully...\n", sig)
+			cancel()
+			break runLoop
+		case <-ticker.C:
+			nextSequence++
+			saveSequence(nextSequence)
+
+			data := telemetry.Data{
+				AgentID:      agentID,
+				Timestamp:    time.Now(),
+				Temperature:  23.5,
+				BatteryLevel: 85,
+				Sequence:     nextSequence,
+			}
 
-		if !sendTelemetry(data) {
-			saveToBuffer(data)
+			if !sendTelemetry(ctx, tr, data) {
+				saveToBuffer(data)
+			}
 		}
 	}
+
+	shutdown(&wg, tr)
+}
+
+// shutdown waits for the background flusher to finish its in-flight
+// processBuffer cycle (bounded by shutdownTimeout), then performs one last
+// synchronous flush so a rolling restart under systemd/Kubernetes doesn't
+// silently drop the last few seconds of telemetry.
+//
+// On a timeout, the flusher's own processBuffer call may still be running
+// even though wg.Wait() hasn't returned; the final processBuffer call below
+// blocks on processBufferMu rather than running concurrently with it.
+func shutdown(wg *sync.WaitGroup, tr transport.Transport) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		fmt.Println("✅ Background flusher stopped cleanly.")
+	case <-time.After(shutdownTimeout):
+		fmt.Println("⚠️ Timed out waiting for background flusher; forcing final flush anyway.")
+	}
+
+	fmt.Println("💾 Attempting final synchronous flush before exit...")
+	fileMutex.Lock()
+	if info, err := os.Stat(bufferFile); err == nil && info.Size() > 0 {
+		rotateBuffer()
+	}
+	fileMutex.Unlock()
+
+	processBuffer(tr, true) // this is our last shot, don't sit out the backoff
+	fmt.Println("👋 Agent stopped.")
 }
 
 // ---------------- NETWORK HELPERS ----------------
 
-func sendTelemetry(data TelemetryData) bool {
-	jsonData, _ := json.Marshal(data)
-	client := http.Client{Timeout: 2 * time.Second}
+func sendTelemetry(ctx context.Context, tr transport.Transport, data telemetry.Data) bool {
+	sendCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err := tr.Send(sendCtx, []telemetry.Data{data})
+	metrics.SendDuration.Observe(time.Since(start).Seconds())
 
-	resp, err := client.Post(serverURL, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
+		metrics.SendFailuresTotal.Inc()
 		return false
 	}
-	defer resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	metrics.SentTotal.Inc()
+	return true
 }
 
 // ---------------- FILE HELPERS ----------------
 
-func saveToBuffer(data TelemetryData) {
+func saveToBuffer(data telemetry.Data) {
 	fileMutex.Lock()
 	defer fileMutex.Unlock()
 
+	if bufferOpenedAt.IsZero() {
+		if _, err := os.Stat(bufferFile); os.IsNotExist(err) {
+			bufferOpenedAt = time.Now()
+		}
+	}
+
 	f, err := os.OpenFile(bufferFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Printf("❌ Error opening buffer: %v", err)
@@ -80,72 +337,297 @@ func saveToBuffer(data TelemetryData) {
 	jsonData, _ := json.Marshal(data)
 	f.Write(append(jsonData, '\n'))
 	fmt.Printf("💾 Buffered: %v\n", data.Timestamp.Format(time.TimeOnly))
+
+	refreshBufferGauges()
 }
 
 // ---------------- BACKGROUND WORKER ----------------
 
-func flushBufferBackground() {
+func flushBufferBackground(ctx context.Context, tr transport.Transport) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		processBuffer()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			processBuffer(tr, false)
+		}
 	}
 }
 
 // The Simplified Logic
-func processBuffer() {
-	// STEP 1: Handle existing processing file (Finish what we started)
+//
+// forceRetry skips any pending backoff wait for this call only, zeroing
+// nextAttemptTime inside the same processBufferMu critical section instead
+// of leaving the caller to mutate it unprotected. shutdown's final flush is
+// the only caller that passes true: it's our last shot before exit, so it
+// shouldn't sit out a backoff window that a normal tick would respect.
+func processBuffer(tr transport.Transport, forceRetry bool) {
+	processBufferMu.Lock()
+	defer processBufferMu.Unlock()
+
+	if forceRetry {
+		nextAttemptTime = time.Time{}
+	}
+
+	start := time.Now()
+	defer func() {
+		metrics.BacklogFlushDuration.Observe(time.Since(start).Seconds())
+		refreshBufferGauges()
+	}()
+
+	// STEP 1: Handle the oldest pending segment (Finish what we started)
 	// We do NOT need the lock here because Main Loop only touches bufferFile.
-	if _, err := os.Stat(processingFile); err == nil {
-		// File exists, try to upload content
-		if uploadBacklogFile(processingFile) {
-			// Success! Remove the file.
-			os.Remove(processingFile)
-			fmt.Println("✅ Backlog batch cleared.")
+	// This must NOT return early: STEP 2 has to run on every tick regardless
+	// of whether STEP 1 is backing off or the upload failed, or a down
+	// server would leave buffer.jsonl rotating and the retention cap
+	// enforcing for the entire outage.
+	if segments := listSegments(); len(segments) > 0 {
+		oldest := segments[0]
+
+		// Respect backoff: don't hammer the server every 5s while it's down.
+		if time.Now().Before(nextAttemptTime) {
+			fmt.Printf("⏳ Backing off, retrying %s in %v\n", oldest, time.Until(nextAttemptTime).Round(time.Second))
 		} else {
-			// Failed. Return and try again next tick.
-			fmt.Println("⚠️ Connection unstable. Retrying batch later.")
-			return
+			// Before retrying a segment that failed before, re-sync with the
+			// server's durable offset: the previous failure may have been a
+			// timeout after the server had already accepted (part of) the
+			// batch, in which case ackedSequence is stale until we ask again.
+			if batchAttempts > 0 {
+				resyncAckedSequence(tr)
+			}
+
+			if uploadSegment(tr, oldest) {
+				// Success! Remove the segment.
+				os.Remove(oldest)
+				batchAttempts = 0
+				nextAttemptTime = time.Time{}
+				fmt.Printf("✅ Backlog segment cleared: %s\n", oldest)
+			} else {
+				// Failed. Bump the attempt counter and schedule the next try.
+				delay := backoffDelay(batchAttempts)
+				batchAttempts++
+				nextAttemptTime = time.Now().Add(delay)
+				fmt.Printf("⚠️ Connection unstable. Retrying %s in %v (attempt %d).\n", oldest, delay.Round(time.Millisecond), batchAttempts)
+			}
 		}
 	}
 
-	// STEP 2: Rotate new data (Grab new work)
-	// We need the lock here because we are moving bufferFile.
+	// STEP 2: Rotate new data and enforce the retention cap.
+	// We need the lock here because we are moving/compressing bufferFile.
 	fileMutex.Lock()
 	defer fileMutex.Unlock()
 
-	// Check if main buffer exists and has data
-	if info, err := os.Stat(bufferFile); err == nil && info.Size() > 0 {
-		// Atomic Rename: buffer.jsonl -> buffer_processing.jsonl
-		os.Rename(bufferFile, processingFile)
-		fmt.Println("🔄 Rotating log file for processing...")
+	if info, err := os.Stat(bufferFile); err == nil && shouldRotate(info.Size(), bufferOpenedAt, time.Now()) {
+		rotateBuffer()
+	}
+
+	enforceRetentionCap()
+}
+
+// shouldRotate reports whether buffer.jsonl should be rotated into a
+// segment given its current size and when it was opened: whichever of
+// MaxBufferBytes or MaxBufferAge is hit first.
+func shouldRotate(size int64, openedAt, now time.Time) bool {
+	if size <= 0 {
+		return false
+	}
+	if size >= MaxBufferBytes {
+		return true
+	}
+	return !openedAt.IsZero() && now.Sub(openedAt) >= MaxBufferAge
+}
+
+// rotateBuffer gzip-compresses buffer.jsonl into a new timestamped segment
+// and clears it, so the live buffer never grows past MaxBufferBytes/Age.
+// Callers must hold fileMutex.
+func rotateBuffer() {
+	segment := fmt.Sprintf("buffer_processing.%d.jsonl.gz", time.Now().UnixNano())
+	if err := gzipToFile(bufferFile, segment); err != nil {
+		log.Printf("❌ Error compressing buffer for rotation: %v", err)
+		return
+	}
+	os.Remove(bufferFile)
+	bufferOpenedAt = time.Time{}
+	fmt.Printf("🔄 Rotated buffer -> %s\n", segment)
+}
+
+// enforceRetentionCap drops the oldest segments until total on-disk segment
+// size is back under MaxTotalBufferBytes, so a multi-day outage can't fill
+// the disk. Callers must hold fileMutex.
+func enforceRetentionCap() {
+	segments := listSegments()
+
+	sizes := make(map[string]int64, len(segments))
+	for _, s := range segments {
+		if info, err := os.Stat(s); err == nil {
+			sizes[s] = info.Size()
+		}
+	}
+
+	for _, oldest := range segmentsToDrop(segments, sizes, MaxTotalBufferBytes) {
+		dropped := countGzipRecords(oldest)
+		os.Remove(oldest)
+		droppedRecordsTotal += uint64(dropped)
+		metrics.DroppedRecordsTotal.Add(float64(dropped))
+		fmt.Printf("🗑️ Buffer over %d bytes, dropped oldest segment %s (%d records, dropped_records_total=%d)\n",
+			MaxTotalBufferBytes, oldest, dropped, droppedRecordsTotal)
+	}
+}
+
+// segmentsToDrop returns the oldest-first prefix of segments that must be
+// removed to bring the total size of segments (per the sizes map) back
+// under maxTotal.
+func segmentsToDrop(segments []string, sizes map[string]int64, maxTotal int64) []string {
+	var total int64
+	for _, s := range segments {
+		total += sizes[s]
+	}
+
+	var drop []string
+	for _, s := range segments {
+		if total <= maxTotal {
+			break
+		}
+		drop = append(drop, s)
+		total -= sizes[s]
+	}
+	return drop
+}
+
+// refreshBufferGauges recomputes telemetry_buffer_bytes and
+// telemetry_buffered_records from what's actually on disk. It's a cheap
+// full recompute rather than incremental tracking, so the gauges can never
+// drift from reality.
+func refreshBufferGauges() {
+	var totalBytes int64
+	var totalRecords int64
+
+	if info, err := os.Stat(bufferFile); err == nil {
+		totalBytes += info.Size()
+		if content, err := os.ReadFile(bufferFile); err == nil && len(content) > 0 {
+			totalRecords += int64(bytes.Count(bytes.TrimRight(content, "\n"), []byte("\n")) + 1)
+		}
+	}
+
+	for _, s := range listSegments() {
+		if info, err := os.Stat(s); err == nil {
+			totalBytes += info.Size()
+		}
+		totalRecords += int64(countGzipRecords(s))
 	}
+
+	metrics.BufferBytes.Set(float64(totalBytes))
+	metrics.BufferedRecords.Set(float64(totalRecords))
 }
 
-// Helper to read a file and upload line-by-line
-func uploadBacklogFile(filepath string) bool {
-	content, err := os.ReadFile(filepath)
+// listSegments returns pending buffer segments, oldest first (segment names
+// embed a UnixNano timestamp, so lexicographic order is chronological).
+func listSegments() []string {
+	segments, _ := filepath.Glob(segmentGlob)
+	return segments
+}
+
+// gzipToFile compresses the contents of src into a new file at dst.
+func gzipToFile(src, dst string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
 	if err != nil {
-		return false // Can't read file? Treat as failure.
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(content); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// countGzipRecords returns the number of JSONL records in a gzip'd segment,
+// used to keep dropped_records_total accurate.
+func countGzipRecords(path string) int {
+	content, err := readGzipFile(path)
+	if err != nil || len(content) == 0 {
+		return 0
+	}
+	return bytes.Count(bytes.TrimRight(content, "\n"), []byte("\n")) + 1
+}
+
+// readGzipFile decompresses a gzip'd segment file in full.
+func readGzipFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return io.ReadAll(gz)
+}
+
+// uploadSegment reads a gzip'd segment and uploads its records in batches of
+// batchSize via the configured transport.
+func uploadSegment(tr transport.Transport, path string) bool {
+	content, err := readGzipFile(path)
+	if err != nil {
+		return false // Can't read segment? Treat as failure.
 	}
 
 	lines := bytes.Split(content, []byte("\n"))
 
+	var batch []telemetry.Data
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tr.Send(ctx, batch); err != nil {
+			return false
+		}
+		for _, d := range batch {
+			if d.Sequence > ackedSequence {
+				ackedSequence = d.Sequence
+			}
+		}
+		fmt.Printf("   ⬆️ Restored upload: %d records\n", len(batch))
+		batch = batch[:0]
+		return true
+	}
+
 	for _, line := range lines {
 		if len(line) == 0 {
 			continue
 		}
 
-		var data TelemetryData
+		var data telemetry.Data
 		if err := json.Unmarshal(line, &data); err == nil {
-			// If we fail to send even ONE line, we abort the whole batch.
-			// This ensures strict ordering and no data gaps.
-			if !sendTelemetry(data) {
-				return false
+			if data.Sequence <= ackedSequence {
+				// Server already durably has this one; a previous attempt
+				// must have partially succeeded before failing the batch.
+				continue
+			}
+			batch = append(batch, data)
+			if len(batch) >= batchSize {
+				// If we fail to send even one batch, we abort the whole
+				// segment upload. This ensures strict ordering and no gaps.
+				if !flush() {
+					return false
+				}
 			}
-			fmt.Printf("   ⬆️ Restored upload: %v\n", data.Timestamp.Format(time.TimeOnly))
 		}
 	}
-	return true
+	return flush()
 }
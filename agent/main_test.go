@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"resilient-telemetry/agent/telemetry"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := backoffDelay(attempt)
+		if delay < 0 {
+			t.Fatalf("attempt %d: delay went negative: %v", attempt, delay)
+		}
+		// backoffCap plus the maximum possible jitter on top of it.
+		maxPossible := time.Duration(float64(backoffCap) * (1 + backoffJitterPct))
+		if delay > maxPossible {
+			t.Fatalf("attempt %d: delay %v exceeded jittered cap %v", attempt, delay, maxPossible)
+		}
+	}
+}
+
+func TestBackoffDelayGrowsThenCaps(t *testing.T) {
+	// Ignoring jitter, attempt 0 should be roughly backoffBase, and a high
+	// attempt number should be clamped at roughly backoffCap rather than
+	// growing unbounded.
+	first := backoffDelay(0)
+	if first < backoffBase/2 || first > backoffBase*2 {
+		t.Fatalf("attempt 0 delay %v not close to backoffBase %v", first, backoffBase)
+	}
+
+	capped := backoffDelay(20)
+	maxPossible := time.Duration(float64(backoffCap) * (1 + backoffJitterPct))
+	if capped > maxPossible {
+		t.Fatalf("attempt 20 delay %v exceeded jittered cap %v", capped, maxPossible)
+	}
+	minPossible := time.Duration(float64(backoffCap) * (1 - backoffJitterPct))
+	if capped < minPossible {
+		t.Fatalf("attempt 20 delay %v did not grow to the jittered cap floor %v", capped, minPossible)
+	}
+}
+
+func TestShouldRotate(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name     string
+		size     int64
+		openedAt time.Time
+		want     bool
+	}{
+		{"empty buffer never rotates", 0, now.Add(-time.Hour), false},
+		{"over size threshold rotates", MaxBufferBytes, now, true},
+		{"under size and fresh does not rotate", 10, now, false},
+		{"under size but past MaxBufferAge rotates", 10, now.Add(-MaxBufferAge - time.Second), true},
+		{"zero openedAt never triggers the age branch", 10, time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := shouldRotate(c.size, c.openedAt, now); got != c.want {
+				t.Errorf("shouldRotate(%d, %v, now) = %v, want %v", c.size, c.openedAt, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSegmentsToDrop(t *testing.T) {
+	segments := []string{"a", "b", "c"}
+	sizes := map[string]int64{"a": 40, "b": 40, "c": 40}
+
+	got := segmentsToDrop(segments, sizes, 50)
+	want := []string{"a", "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("segmentsToDrop over cap = %v, want %v", got, want)
+	}
+
+	if got := segmentsToDrop(segments, sizes, 1000); len(got) != 0 {
+		t.Fatalf("segmentsToDrop under cap = %v, want none dropped", got)
+	}
+}
+
+type fakeTransport struct {
+	sent [][]telemetry.Data
+}
+
+func (f *fakeTransport) Send(ctx context.Context, records []telemetry.Data) error {
+	cp := make([]telemetry.Data, len(records))
+	copy(cp, records)
+	f.sent = append(f.sent, cp)
+	return nil
+}
+
+func (f *fakeTransport) Close() error { return nil }
+
+type alwaysFailTransport struct{}
+
+func (alwaysFailTransport) Send(ctx context.Context, records []telemetry.Data) error {
+	return errors.New("server unreachable")
+}
+
+func (alwaysFailTransport) Close() error { return nil }
+
+// writeSparseFile creates a file reporting the given size via os.Stat
+// without actually writing size bytes, so tests can simulate a buffer
+// that's grown huge without spending real time/disk on it.
+func writeSparseFile(t *testing.T, path string, size int64) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create %s: %v", path, err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("truncate %s: %v", path, err)
+	}
+}
+
+// TestProcessBufferRotatesAndEnforcesCapDuringOutage guards against
+// rotation/retention being starved by an always-failing transport: a
+// multi-day outage must not be able to grow buffer.jsonl or the total
+// segment size without bound just because every upload attempt fails.
+func TestProcessBufferRotatesAndEnforcesCapDuringOutage(t *testing.T) {
+	origWd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	defer os.Chdir(origWd)
+
+	origAttempts, origNextAttempt := batchAttempts, nextAttemptTime
+	origOpenedAt, origDropped := bufferOpenedAt, droppedRecordsTotal
+	defer func() {
+		batchAttempts, nextAttemptTime = origAttempts, origNextAttempt
+		bufferOpenedAt, droppedRecordsTotal = origOpenedAt, origDropped
+	}()
+	batchAttempts = 0
+	nextAttemptTime = time.Time{}
+	droppedRecordsTotal = 0
+
+	// A pending segment with real, readable content so the upload attempt
+	// actually reaches the transport instead of failing on a read error.
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	line, _ := json.Marshal(telemetry.Data{AgentID: "agent-001", Sequence: 1})
+	gz.Write(append(line, '\n'))
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	oldestSegment := "buffer_processing.1000.jsonl.gz"
+	if err := os.WriteFile(oldestSegment, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write segment: %v", err)
+	}
+
+	// buffer.jsonl already past MaxBufferBytes, so STEP 2 must rotate it
+	// regardless of what happens to the pending segment above.
+	if err := os.WriteFile(bufferFile, make([]byte, MaxBufferBytes+1), 0644); err != nil {
+		t.Fatalf("write buffer file: %v", err)
+	}
+	bufferOpenedAt = time.Now()
+
+	tr := alwaysFailTransport{}
+
+	// Tick 1: the upload-failure branch. Before the fix, its `return`
+	// skipped rotation entirely.
+	processBuffer(tr, false)
+
+	if _, err := os.Stat(bufferFile); !os.IsNotExist(err) {
+		t.Fatalf("tick 1: buffer.jsonl should have rotated away, stat err = %v", err)
+	}
+	if segs := listSegments(); len(segs) != 2 {
+		t.Fatalf("tick 1: want oldest segment plus a freshly rotated one, got %v", segs)
+	}
+	if nextAttemptTime.IsZero() || !nextAttemptTime.After(time.Now()) {
+		t.Fatalf("tick 1: expected backoff to be scheduled after a failed upload")
+	}
+
+	// Pile on enough already-on-disk segment bytes to blow past
+	// MaxTotalBufferBytes before tick 2.
+	writeSparseFile(t, "buffer_processing.2000.jsonl.gz", MaxTotalBufferBytes)
+
+	// Tick 2: the backoff-wait branch (nextAttemptTime is still in the
+	// future from tick 1). Before the fix, its `return` also skipped
+	// rotation and retention.
+	processBuffer(tr, false)
+
+	var total int64
+	for _, s := range listSegments() {
+		info, err := os.Stat(s)
+		if err != nil {
+			t.Fatalf("stat %s: %v", s, err)
+		}
+		total += info.Size()
+	}
+	if total > MaxTotalBufferBytes {
+		t.Fatalf("tick 2: retention cap not enforced during backoff, total segment bytes = %d, want <= %d", total, MaxTotalBufferBytes)
+	}
+	if droppedRecordsTotal == 0 {
+		t.Fatalf("tick 2: expected enforceRetentionCap to have dropped at least one segment's records")
+	}
+}
+
+// TestProcessBufferForceRetryIgnoresBackoff covers shutdown's last-shot
+// call: forceRetry must clear a still-pending backoff window before STEP 1
+// runs, inside processBufferMu's critical section, rather than leaving the
+// caller to zero nextAttemptTime unprotected.
+func TestProcessBufferForceRetryIgnoresBackoff(t *testing.T) {
+	origWd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	defer os.Chdir(origWd)
+
+	origAttempts, origNextAttempt := batchAttempts, nextAttemptTime
+	defer func() { batchAttempts, nextAttemptTime = origAttempts, origNextAttempt }()
+	batchAttempts = 1
+	nextAttemptTime = time.Now().Add(time.Hour)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	line, _ := json.Marshal(telemetry.Data{AgentID: "agent-001", Sequence: 1})
+	gz.Write(append(line, '\n'))
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	segment := "buffer_processing.1000.jsonl.gz"
+	if err := os.WriteFile(segment, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write segment: %v", err)
+	}
+
+	tr := &fakeTransport{}
+	processBuffer(tr, true)
+
+	if len(tr.sent) == 0 {
+		t.Fatalf("forceRetry should have uploaded the pending segment despite nextAttemptTime being in the future")
+	}
+	if _, err := os.Stat(segment); !os.IsNotExist(err) {
+		t.Fatalf("segment should have been removed after a successful forced retry, stat err = %v", err)
+	}
+}
+
+func TestUploadSegmentSkipsAlreadyAckedSequences(t *testing.T) {
+	origAcked := ackedSequence
+	defer func() { ackedSequence = origAcked }()
+	ackedSequence = 2
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, seq := range []uint64{1, 2, 3, 4} {
+		line, _ := json.Marshal(telemetry.Data{AgentID: "agent-001", Sequence: seq})
+		gz.Write(append(line, '\n'))
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "segment.jsonl.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write segment: %v", err)
+	}
+
+	tr := &fakeTransport{}
+	if !uploadSegment(tr, path) {
+		t.Fatalf("uploadSegment reported failure")
+	}
+
+	var gotSeqs []uint64
+	for _, batch := range tr.sent {
+		for _, d := range batch {
+			gotSeqs = append(gotSeqs, d.Sequence)
+		}
+	}
+	if want := []uint64{3, 4}; !reflect.DeepEqual(gotSeqs, want) {
+		t.Fatalf("sent sequences = %v, want %v (<=2 already acked should be skipped)", gotSeqs, want)
+	}
+	if ackedSequence != 4 {
+		t.Fatalf("ackedSequence = %d, want 4 after a successful batch", ackedSequence)
+	}
+}
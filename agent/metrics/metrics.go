@@ -0,0 +1,65 @@
+// Package metrics exposes the agent's operational counters and histograms
+// over a Prometheus /metrics endpoint. A fleet of agents in the field is
+// otherwise only observable through log lines, which doesn't scale past a
+// handful of hosts.
+package metrics
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	SentTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "telemetry_sent_total",
+		Help: "Total number of live telemetry records successfully sent. Backlog segment uploads are not counted.",
+	})
+
+	SendFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "telemetry_send_failures_total",
+		Help: "Total number of live telemetry records that failed to send. Failed backlog segment uploads are not counted.",
+	})
+
+	BufferedRecords = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telemetry_buffered_records",
+		Help: "Number of telemetry records currently sitting in the on-disk buffer, including pending segments.",
+	})
+
+	BufferBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telemetry_buffer_bytes",
+		Help: "Total on-disk size in bytes of buffer.jsonl plus all pending segments.",
+	})
+
+	DroppedRecordsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "telemetry_dropped_records_total",
+		Help: "Total number of records dropped because the buffer retention cap was exceeded.",
+	})
+
+	SendDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "telemetry_send_duration_seconds",
+		Help:    "Latency of a single send to the configured transport.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	BacklogFlushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "telemetry_backlog_flush_duration_seconds",
+		Help:    "Duration of one processBuffer cycle (rotation, retention, and segment upload).",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// StartServer serves /metrics on addr. It blocks, so callers run it with
+// `go metrics.StartServer(addr)`.
+func StartServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("📊 Metrics listening on %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("❌ Metrics server stopped: %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+// Package telemetry defines the wire format shared by every transport
+// implementation, so buffering, batching, and dedup logic in main don't need
+// to know whether records end up going out over HTTP, gRPC, or MQTT.
+package telemetry
+
+import "time"
+
+// Data is a single telemetry sample collected from the host.
+type Data struct {
+	AgentID      string    `json:"agent_id"`
+	Timestamp    time.Time `json:"timestamp"`
+	Temperature  float64   `json:"temperature"`
+	BatteryLevel int       `json:"battery_level"`
+
+	// Sequence is a monotonic, per-agent counter starting at 1. It lets a
+	// collector durably dedup records it has already accepted even when a
+	// retried batch partially overlaps one it already stored.
+	Sequence uint64 `json:"sequence"`
+}
@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+
+	"resilient-telemetry/agent/telemetry"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// publishTelemetryMethod is the client-streaming RPC the collector exposes:
+// the agent keeps one stream open per connection and pushes records to it
+// across ticks. On error the stream is dropped without an ack; only a
+// shutdown (Close) half-closes it and reads the collector's ack back.
+const publishTelemetryMethod = "/telemetry.TelemetryService/PublishTelemetry"
+
+// publishAck is what the collector replies with once the stream is closed.
+type publishAck struct {
+	Received int64 `json:"received"`
+}
+
+// GRPCTransport keeps a single long-lived PublishTelemetry stream open and
+// reuses it across ticks. On error it's torn down and rebuilt lazily on the
+// next Send, rather than paying a fresh dial + handshake every batch.
+type GRPCTransport struct {
+	addr string
+
+	mu           sync.Mutex
+	conn         *grpc.ClientConn
+	stream       grpc.ClientStream
+	streamCancel context.CancelFunc
+}
+
+// NewGRPCTransport returns a GRPCTransport targeting addr. Dialing happens
+// lazily on the first Send.
+func NewGRPCTransport(addr string) (*GRPCTransport, error) {
+	return &GRPCTransport{addr: addr}, nil
+}
+
+func (t *GRPCTransport) Send(ctx context.Context, records []telemetry.Data) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stream == nil {
+		if err := t.dial(); err != nil {
+			return err
+		}
+	}
+	// Captured so the goroutine below never touches t.stream directly: if
+	// ctx wins the race, we reset() while that goroutine may still be
+	// blocked in SendMsg, and reset() is about to make t.stream point
+	// somewhere else (or nil) out from under it.
+	stream := t.stream
+
+	done := make(chan error, 1)
+	go func() {
+		for i := range records {
+			if err := stream.SendMsg(&records[i]); err != nil {
+				done <- fmt.Errorf("transport: grpc send failed: %w", err)
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.reset()
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		// SendMsg is still blocked on the wire; we can't wait for it
+		// without blocking the caller past its own deadline (which, for
+		// sendTelemetry, would stall the main select loop and with it
+		// signal handling). Tear the stream down so the wedged goroutine's
+		// eventual result is discarded and the next Send redials instead of
+		// reusing a connection that may never unblock.
+		t.reset()
+		return fmt.Errorf("transport: grpc send timed out: %w", ctx.Err())
+	}
+}
+
+// dial opens the connection and the PublishTelemetry stream. The stream is
+// opened against a context owned by the transport itself, not the caller's
+// per-call ctx: grpc.ClientConn.NewStream keeps ctx alive for the lifetime
+// of the stream, and a per-Send ctx gets canceled (via the caller's
+// `defer cancel()`) the instant that one call returns, which would tear the
+// stream down before it could ever be reused on the next tick. Callers must
+// hold t.mu.
+func (t *GRPCTransport) dial() error {
+	conn, err := grpc.NewClient(t.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("transport: grpc dial failed: %w", err)
+	}
+
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stream, err := conn.NewStream(streamCtx, &grpc.StreamDesc{
+		StreamName:    "PublishTelemetry",
+		ClientStreams: true,
+	}, publishTelemetryMethod, grpc.CallContentSubtype(jsonCodec{}.Name()))
+	if err != nil {
+		cancel()
+		conn.Close()
+		return fmt.Errorf("transport: grpc stream open failed: %w", err)
+	}
+
+	t.conn = conn
+	t.stream = stream
+	t.streamCancel = cancel
+	return nil
+}
+
+// reset drops the current connection and stream so the next Send rebuilds
+// them from scratch. Callers must hold t.mu.
+func (t *GRPCTransport) reset() {
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	if t.streamCancel != nil {
+		t.streamCancel()
+	}
+	t.conn = nil
+	t.stream = nil
+	t.streamCancel = nil
+}
+
+func (t *GRPCTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stream == nil {
+		return nil
+	}
+
+	if err := t.stream.CloseSend(); err != nil {
+		t.reset()
+		return err
+	}
+	var ack publishAck
+	err := t.stream.RecvMsg(&ack)
+	t.reset()
+	return err
+}
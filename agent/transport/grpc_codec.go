@@ -0,0 +1,21 @@
+package transport
+
+import "encoding/json"
+
+// jsonCodec lets GRPCTransport talk to a collector without a protoc build
+// step: it marshals gRPC messages as JSON instead of wire-format protobuf.
+// The collector registers the same codec (or fronts the RPC with a
+// JSON-aware gateway) to understand it.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
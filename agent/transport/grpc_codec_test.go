@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"resilient-telemetry/agent/telemetry"
+)
+
+func TestJSONCodecRoundTrip(t *testing.T) {
+	codec := jsonCodec{}
+
+	if got := codec.Name(); got != "json" {
+		t.Fatalf("Name() = %q, want %q", got, "json")
+	}
+
+	want := telemetry.Data{
+		AgentID:      "agent-001",
+		Timestamp:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Temperature:  21.5,
+		BatteryLevel: 87,
+		Sequence:     42,
+	}
+
+	encoded, err := codec.Marshal(&want)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var got telemetry.Data
+	if err := codec.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	if !got.Timestamp.Equal(want.Timestamp) {
+		t.Fatalf("Timestamp = %v, want %v", got.Timestamp, want.Timestamp)
+	}
+	got.Timestamp = want.Timestamp
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("round trip = %+v, want %+v", got, want)
+	}
+}
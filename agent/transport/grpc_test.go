@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"resilient-telemetry/agent/telemetry"
+)
+
+// newBufconnGRPCTransport starts srv (already configured with the
+// PublishTelemetry stream handler to test against) on an in-memory
+// bufconn listener, dials it, opens the stream the same way
+// GRPCTransport.dial would, and returns a ready-to-use GRPCTransport. Server,
+// connection and stream are torn down via t.Cleanup.
+func newBufconnGRPCTransport(t *testing.T, srv *grpc.Server) *GRPCTransport {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(dialer),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stream, err := conn.NewStream(streamCtx, &grpc.StreamDesc{
+		StreamName:    "PublishTelemetry",
+		ClientStreams: true,
+	}, publishTelemetryMethod, grpc.CallContentSubtype(jsonCodec{}.Name()))
+	if err != nil {
+		cancel()
+		t.Fatalf("NewStream: %v", err)
+	}
+	t.Cleanup(cancel)
+
+	return &GRPCTransport{addr: "bufnet", conn: conn, stream: stream, streamCancel: cancel}
+}
+
+// registerPublishTelemetry wires handler up as the collector's
+// PublishTelemetry client-streaming RPC, matching publishTelemetryMethod.
+func registerPublishTelemetry(srv *grpc.Server, handler grpc.StreamHandler) {
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "telemetry.TelemetryService",
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "PublishTelemetry",
+				Handler:       handler,
+				ClientStreams: true,
+			},
+		},
+	}, nil)
+}
+
+func TestGRPCTransportSendDelivers(t *testing.T) {
+	received := make(chan telemetry.Data, 1)
+
+	srv := grpc.NewServer()
+	registerPublishTelemetry(srv, func(_ any, stream grpc.ServerStream) error {
+		var d telemetry.Data
+		if err := stream.RecvMsg(&d); err != nil {
+			return err
+		}
+		received <- d
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	})
+
+	tr := newBufconnGRPCTransport(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Send(ctx, []telemetry.Data{{AgentID: "agent-001", Sequence: 7}}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	select {
+	case d := <-received:
+		if d.AgentID != "agent-001" || d.Sequence != 7 {
+			t.Fatalf("server received %+v, want AgentID=agent-001 Sequence=7", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server never received the record")
+	}
+}
+
+// TestGRPCTransportSendHonorsCtxDeadline reproduces a wedged connection: the
+// server never reads, so a payload bigger than gRPC's default per-stream
+// flow-control window (64KiB) leaves SendMsg blocked indefinitely. Send must
+// still return once ctx is done, and must tear the stream down so the next
+// Send redials instead of reusing a connection that may never unblock.
+func TestGRPCTransportSendHonorsCtxDeadline(t *testing.T) {
+	blockedUntilTestEnds := make(chan struct{})
+	defer close(blockedUntilTestEnds)
+
+	srv := grpc.NewServer()
+	registerPublishTelemetry(srv, func(_ any, stream grpc.ServerStream) error {
+		<-blockedUntilTestEnds // never call RecvMsg, so the window never opens
+		return nil
+	})
+
+	tr := newBufconnGRPCTransport(t, srv)
+
+	// writeQuota.get only blocks once local quota has already gone
+	// negative (it lets a single oversized write through, then blocks the
+	// next one): send two oversized records so the first drains the quota
+	// and the second genuinely blocks with nobody on the other end to
+	// replenish it.
+	oversized := telemetry.Data{AgentID: strings.Repeat("x", 4*1024*1024)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := tr.Send(ctx, []telemetry.Data{oversized, oversized})
+	if err == nil {
+		t.Fatalf("Send should have failed once ctx's deadline passed")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Send took %v to return after a 200ms deadline", elapsed)
+	}
+
+	tr.mu.Lock()
+	stillOpen := tr.stream != nil
+	tr.mu.Unlock()
+	if stillOpen {
+		t.Fatalf("Send should have reset() the stream on timeout so the next call redials")
+	}
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"resilient-telemetry/agent/telemetry"
+)
+
+// HTTPTransport POSTs gzip-compressed JSON batches to a /telemetry/batch
+// endpoint. It's the original transport and the default for existing
+// deployments.
+type HTTPTransport struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPTransport returns an HTTPTransport that posts batches to url.
+func NewHTTPTransport(url string) *HTTPTransport {
+	return &HTTPTransport{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, records []telemetry.Data) error {
+	jsonData, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	var gzBuf bytes.Buffer
+	gz := gzip.NewWriter(&gzBuf)
+	if _, err := gz.Write(jsonData); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, &gzBuf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("transport: server returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (t *HTTPTransport) Close() error { return nil }
+
+// LastAck asks the collector what sequence it has durably received for
+// agentID, via GET <base>/telemetry/ack?agent_id=<agentID>.
+func (t *HTTPTransport) LastAck(ctx context.Context, agentID string) (uint64, error) {
+	u, err := url.Parse(t.ackURL())
+	if err != nil {
+		return 0, err
+	}
+	q := u.Query()
+	q.Set("agent_id", agentID)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("transport: ack endpoint returned %s", resp.Status)
+	}
+
+	var ack struct {
+		ServerAck uint64 `json:"server_ack"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil {
+		return 0, err
+	}
+	return ack.ServerAck, nil
+}
+
+// ackURL derives the /telemetry/ack endpoint from the batch upload URL.
+func (t *HTTPTransport) ackURL() string {
+	return strings.TrimSuffix(t.url, "/batch") + "/ack"
+}
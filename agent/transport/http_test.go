@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPTransportLastAckEscapesAgentID(t *testing.T) {
+	var gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		w.Write([]byte(`{"server_ack": 5}`))
+	}))
+	defer srv.Close()
+
+	tr := NewHTTPTransport(srv.URL + "/telemetry/batch")
+
+	ack, err := tr.LastAck(context.Background(), "agent 001&evil=1")
+	if err != nil {
+		t.Fatalf("LastAck: %v", err)
+	}
+	if ack != 5 {
+		t.Fatalf("ack = %d, want 5", ack)
+	}
+	if want := "agent_id=agent+001%26evil%3D1"; gotQuery != want {
+		t.Fatalf("query = %q, want %q", gotQuery, want)
+	}
+}
@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"resilient-telemetry/agent/telemetry"
+)
+
+// MQTTTransport publishes each batch as one retained-free message to
+// telemetry/<agent_id> at QoS 1, leaving at-least-once delivery to the
+// broker instead of the agent's own disk buffer.
+type MQTTTransport struct {
+	agentID string
+	client  mqtt.Client
+}
+
+// NewMQTTTransport returns a MQTTTransport that publishes under the given
+// agentID's topic. Connecting to the broker is left to run in the
+// background (see ConnectRetry below) rather than done here, so the agent
+// can start and buffer to disk even if the broker isn't reachable yet.
+func NewMQTTTransport(broker, agentID string) (*MQTTTransport, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("resilient-telemetry-%s", agentID)).
+		SetConnectTimeout(5 * time.Second).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetAutoReconnect(true)
+
+	client := mqtt.NewClient(opts)
+	// Fire-and-forget: with ConnectRetry set, paho retries against the
+	// broker in the background instead of blocking (or failing) here. The
+	// HTTP and gRPC transports dial lazily too, and a broker that's still
+	// unreachable by the first Send just surfaces as a Send failure, which
+	// the caller already buffers to disk and retries like any other.
+	client.Connect()
+
+	return &MQTTTransport{agentID: agentID, client: client}, nil
+}
+
+func (t *MQTTTransport) Send(ctx context.Context, records []telemetry.Data) error {
+	payload, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	topic := fmt.Sprintf("telemetry/%s", t.agentID)
+	token := t.client.Publish(topic, 1, false, payload)
+
+	done := make(chan struct{})
+	go func() {
+		token.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *MQTTTransport) Close() error {
+	t.client.Disconnect(250)
+	return nil
+}
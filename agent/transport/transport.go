@@ -0,0 +1,47 @@
+// Package transport abstracts how batches of telemetry leave the agent. The
+// buffering and retry logic in main only ever talks to the Transport
+// interface, so the wire protocol is a per-deployment choice instead of a
+// hardcoded one.
+package transport
+
+import (
+	"context"
+	"fmt"
+
+	"resilient-telemetry/agent/telemetry"
+)
+
+// Transport sends batches of telemetry to a collector. Implementations are
+// selected per deployment via the --transport flag.
+type Transport interface {
+	// Send delivers a batch of records. A non-nil error means the batch
+	// as a whole was not confirmed; some of its records may still have
+	// reached the collector before the failure, so retrying the whole batch
+	// can resend records the collector already holds.
+	Send(ctx context.Context, records []telemetry.Data) error
+	Close() error
+}
+
+// AckSource is implemented by transports that can report the last sequence
+// a collector has durably received for an agent, so the caller can skip
+// re-sending records it already has. Transports without a natural notion of
+// a durable server-side offset (gRPC, MQTT) don't need to implement it.
+type AckSource interface {
+	LastAck(ctx context.Context, agentID string) (uint64, error)
+}
+
+// New builds the Transport named by kind ("http", "grpc", or "mqtt"), wired
+// against target (a URL, a gRPC address, or a broker address, depending on
+// kind).
+func New(kind, target, agentID string) (Transport, error) {
+	switch kind {
+	case "", "http":
+		return NewHTTPTransport(target), nil
+	case "grpc":
+		return NewGRPCTransport(target)
+	case "mqtt":
+		return NewMQTTTransport(target, agentID)
+	default:
+		return nil, fmt.Errorf("transport: unknown kind %q", kind)
+	}
+}